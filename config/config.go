@@ -15,22 +15,59 @@ type AppConfig struct {
 	EnvMode           string
 	ContactMail       string
 	LogoURL           string
+	WebsiteURL        string
+	SupportPhone      string
+	ResponseTime      string
 
-	SMTPServer string
-	SMTPMail   string
-	SMTPSecret string
-	SMTPPort   int
-	RateLimit  int
+	// AutoReplyCooldownMinutes is how long to wait before auto-replying to
+	// the same address again; 0 disables the cooldown
+	AutoReplyCooldownMinutes int
+
+	SMTPServer     string
+	SMTPMail       string
+	SMTPSecret     string
+	SMTPPort       int
+	SMTPTimeout    int
+	SMTPMaxRetries int
+	// SMTPRetryBudget caps, in seconds, the time a contact request spends
+	// retrying; no retry starts after it. A request can still take the budget
+	// plus one attempt per message, each attempt bounded by SMTPTimeout per
+	// phase (connect, handshake, data).
+	SMTPRetryBudget int
+	SMTPAuthType    string
+	SMTPPoolSize    int
+	RateLimit       int
+
+	SMTPOAuthAccessToken  string
+	SMTPOAuthRefreshToken string
+	SMTPOAuthClientID     string
+	SMTPOAuthClientSecret string
+	SMTPOAuthTokenURL     string
+
+	// Applied to STARTTLS, which is used whenever the server offers it
+	SMTPSkipTLSVerify bool
+	SMTPMinTLSVersion string
+	SMTPCACertFile    string
+
+	DKIMDomain         string
+	DKIMSelector       string
+	DKIMPrivateKeyFile string
 }
 
 var (
-	LogoURL = "https://dhawalhost.com/img/general/dhlogov.png"
-	MailTo  = "dhawalhost@gmail.com"
+	LogoURL    = "https://dhawalhost.com/img/general/dhlogov.png"
+	MailTo     = "dhawalhost@gmail.com"
+	WebsiteURL = "https://dhawalhost.com"
 )
 
 // Load configuration from environment file using Viper
 func LoadConfig() AppConfig {
 	viper.SetConfigFile(".env")
+	viper.SetDefault("SMTP_TIMEOUT", 10)
+	viper.SetDefault("SMTP_MAX_RETRIES", 3)
+	viper.SetDefault("SMTP_RETRY_BUDGET", 10)
+	viper.SetDefault("SMTP_POOL_SIZE", 2)
+	viper.SetDefault("AUTO_REPLY_COOLDOWN_MINUTES", 60)
 	if err := viper.ReadInConfig(); err != nil {
 		panic(err)
 	}
@@ -40,11 +77,35 @@ func LoadConfig() AppConfig {
 	appConfig.EnvMode = viper.GetString("ENV_MODE")
 	appConfig.ContactMail = viper.GetString("CONTACT_MAIL")
 	appConfig.LogoURL = viper.GetString("LOGO_URL")
+	appConfig.WebsiteURL = viper.GetString("WEBSITE_URL")
+	appConfig.SupportPhone = viper.GetString("SUPPORT_PHONE")
+	appConfig.ResponseTime = viper.GetString("RESPONSE_TIME")
+	appConfig.AutoReplyCooldownMinutes = viper.GetInt("AUTO_REPLY_COOLDOWN_MINUTES")
 	appConfig.SMTPServer = viper.GetString("SMTP_SERVER_URL")
 	appConfig.SMTPMail = viper.GetString("SMTP_USER")
 	appConfig.SMTPSecret = viper.GetString("SMTP_SECRET")
 	appConfig.SMTPPort = viper.GetInt("SMTP_PORT")
+	appConfig.SMTPTimeout = viper.GetInt("SMTP_TIMEOUT")
+	appConfig.SMTPMaxRetries = viper.GetInt("SMTP_MAX_RETRIES")
+	appConfig.SMTPRetryBudget = viper.GetInt("SMTP_RETRY_BUDGET")
+	appConfig.SMTPAuthType = viper.GetString("SMTP_AUTH_TYPE")
+	appConfig.SMTPPoolSize = viper.GetInt("SMTP_POOL_SIZE")
+	appConfig.SMTPOAuthAccessToken = viper.GetString("SMTP_OAUTH_ACCESS_TOKEN")
+	appConfig.SMTPOAuthRefreshToken = viper.GetString("SMTP_OAUTH_REFRESH_TOKEN")
+	appConfig.SMTPOAuthClientID = viper.GetString("SMTP_OAUTH_CLIENT_ID")
+	appConfig.SMTPOAuthClientSecret = viper.GetString("SMTP_OAUTH_CLIENT_SECRET")
+	appConfig.SMTPOAuthTokenURL = viper.GetString("SMTP_OAUTH_TOKEN_URL")
+	appConfig.SMTPSkipTLSVerify = viper.GetBool("SMTP_SKIP_TLS_VERIFY")
+	appConfig.SMTPMinTLSVersion = viper.GetString("SMTP_MIN_TLS_VERSION")
+	appConfig.SMTPCACertFile = viper.GetString("SMTP_CA_CERT_FILE")
+	appConfig.DKIMDomain = viper.GetString("DKIM_DOMAIN")
+	appConfig.DKIMSelector = viper.GetString("DKIM_SELECTOR")
+	appConfig.DKIMPrivateKeyFile = viper.GetString("DKIM_PRIVATE_KEY_FILE")
 	appConfig.RateLimit = viper.GetInt("RATE_LIMIT")
+
+	if appConfig.WebsiteURL == "" {
+		appConfig.WebsiteURL = WebsiteURL
+	}
 	return appConfig
 }
 
@@ -3,8 +3,10 @@ module github.com/dhawalhost/leapmailr
 go 1.20
 
 require (
+	github.com/emersion/go-msgauth v0.6.8
 	github.com/gin-gonic/gin v1.9.1
 	github.com/spf13/viper v1.18.2
+	golang.org/x/net v0.23.0
 	golang.org/x/time v0.5.0
 )
 
@@ -42,7 +44,6 @@ require (
 	golang.org/x/arch v0.3.0 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
-	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dhawalhost/leapmailr/config"
 	"github.com/dhawalhost/leapmailr/models"
@@ -20,10 +21,33 @@ func HandleContactForm(c *gin.Context) {
 		return
 	}
 	smtpServer := models.SMTPDetails{
-		Server: conf.SMTPServer,
-		Port:   conf.SMTPPort,
-		Email:  conf.SMTPMail,
-		Secret: conf.SMTPSecret,
+		Server:     conf.SMTPServer,
+		Port:       conf.SMTPPort,
+		Email:      conf.SMTPMail,
+		Secret:     conf.SMTPSecret,
+		Timeout:    time.Duration(conf.SMTPTimeout) * time.Second,
+		MaxRetries: conf.SMTPMaxRetries,
+		// Both emails share one deadline so retries can't stack up per request
+		RetryDeadline: time.Now().Add(time.Duration(conf.SMTPRetryBudget) * time.Second),
+		AuthType:      conf.SMTPAuthType,
+		PoolSize:      conf.SMTPPoolSize,
+		OAuth: models.OAuthDetails{
+			AccessToken:  conf.SMTPOAuthAccessToken,
+			RefreshToken: conf.SMTPOAuthRefreshToken,
+			ClientID:     conf.SMTPOAuthClientID,
+			ClientSecret: conf.SMTPOAuthClientSecret,
+			TokenURL:     conf.SMTPOAuthTokenURL,
+		},
+		TLS: models.TLSOptions{
+			SkipVerify: conf.SMTPSkipTLSVerify,
+			MinVersion: conf.SMTPMinTLSVersion,
+			CACertFile: conf.SMTPCACertFile,
+		},
+		DKIM: models.DKIMOptions{
+			Domain:         conf.DKIMDomain,
+			Selector:       conf.DKIMSelector,
+			PrivateKeyFile: conf.DKIMPrivateKeyFile,
+		},
 	}
 
 	// Sender and recipient details
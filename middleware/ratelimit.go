@@ -1,11 +1,15 @@
 package middleware
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/dhawalhost/leapmailr/ratelimit"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
 )
 
 func LimitMiddleware() gin.HandlerFunc {
@@ -13,11 +17,47 @@ func LimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
 		limiter := limiter.GetLimiter(ip)
-		if !limiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+
+		now := time.Now()
+		reservation := limiter.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			// Give the token back so a throttled client doesn't push its own reset further out
+			reservation.CancelAt(now)
+		}
+
+		limit, remaining, reset := rateLimitState(limiter, now)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(reset))
+
+		if delay > 0 {
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Rate limit exceeded",
+				"limit":       limit,
+				"remaining":   remaining,
+				"reset":       reset,
+				"retry_after": retryAfter,
+			})
 			c.Abort()
 			return
 		}
 		c.Next()
 	}
 }
+
+// rateLimitState returns the bucket size, the whole requests still available
+// and the number of seconds until the bucket is full again
+func rateLimitState(limiter *rate.Limiter, now time.Time) (limit, remaining, reset int) {
+	limit = limiter.Burst()
+	tokens := limiter.TokensAt(now)
+	if tokens > 0 {
+		remaining = int(tokens)
+	}
+	if missing := float64(limit) - tokens; missing > 0 && limiter.Limit() > 0 {
+		reset = int(math.Ceil(missing / float64(limiter.Limit())))
+	}
+	return
+}
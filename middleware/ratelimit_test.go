@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLimitMiddlewareThrottledResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(LimitMiddleware())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The bucket holds two requests
+	for i := 0; i < 2; i++ {
+		if w := serve(); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+
+	w := serve()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	headers := map[string]string{
+		"Retry-After":           "1",
+		"X-RateLimit-Limit":     "2",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     "2",
+	}
+	for name, want := range headers {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	var body struct {
+		Error      string `json:"error"`
+		Limit      int    `json:"limit"`
+		Remaining  int    `json:"remaining"`
+		Reset      int    `json:"reset"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding body %q: %v", w.Body.String(), err)
+	}
+	if body.Error != "Rate limit exceeded" || body.Limit != 2 || body.Remaining != 0 || body.Reset != 2 || body.RetryAfter != 1 {
+		t.Errorf("unexpected body %+v", body)
+	}
+}
+
+func TestLimitMiddlewareAllowedRequestHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(LimitMiddleware())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("X-RateLimit-Remaining = %q, want %q", got, "1")
+	}
+	if got := w.Header().Get("Retry-After"); got != "" {
+		t.Errorf("Retry-After = %q on an allowed request, want none", got)
+	}
+}
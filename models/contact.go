@@ -5,6 +5,9 @@ type ContactReplyData struct {
 	SenderName    string
 	Logo          string
 	MailTo        string
+	WebsiteURL    string
+	SupportPhone  string
+	ResponseTime  string
 }
 
 type ContactUsData struct {
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Sender struct {
 	Name  string
 	Email string
@@ -12,8 +14,47 @@ type Recipient struct {
 }
 
 type SMTPDetails struct {
-	Server string
-	Port   int
-	Email  string
-	Secret string
+	Server  string
+	Port    int
+	Email   string
+	Secret  string
+	Timeout time.Duration
+	// MaxRetries is how many times a transient delivery failure is retried
+	MaxRetries int
+	// RetryDeadline stops new retries from starting after it; zero means no limit
+	RetryDeadline time.Time
+	// AuthType is one of cram-md5 (default), plain or xoauth2
+	AuthType string
+	OAuth    OAuthDetails
+	// PoolSize is how many idle connections to keep for reuse; 0 disables pooling
+	PoolSize int
+	TLS      TLSOptions
+	DKIM     DKIMOptions
+}
+
+// TLSOptions tunes the STARTTLS handshake. The zero value verifies the
+// server certificate against the system roots.
+type TLSOptions struct {
+	SkipVerify bool
+	// MinVersion is one of 1.0, 1.1, 1.2 or 1.3; empty keeps the Go default
+	MinVersion string
+	CACertFile string
+}
+
+// OAuthDetails holds the credentials used for XOAUTH2. A static AccessToken is
+// used as is; otherwise one is minted from the RefreshToken at TokenURL.
+type OAuthDetails struct {
+	AccessToken  string
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+	TokenURL     string
+}
+
+// DKIMOptions configures DKIM signing of outgoing mail; signing is skipped
+// when PrivateKeyFile is empty
+type DKIMOptions struct {
+	Domain         string
+	Selector       string
+	PrivateKeyFile string
 }
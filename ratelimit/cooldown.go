@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Cooldown allows an action once per key within a time window
+type Cooldown struct {
+	last      map[string]time.Time
+	mu        *sync.Mutex
+	window    time.Duration
+	lastSweep time.Time
+}
+
+// NewCooldown .
+func NewCooldown(window time.Duration) *Cooldown {
+	return &Cooldown{
+		last:      make(map[string]time.Time),
+		mu:        &sync.Mutex{},
+		window:    window,
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve reports whether key is outside its cooldown window and, if so,
+// starts a new window for it in the same step, so concurrent callers can't
+// both get through
+func (c *Cooldown) Reserve(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+
+	if last, exists := c.last[key]; exists && now.Sub(last) < c.window {
+		return false
+	}
+	if c.window > 0 {
+		c.last[key] = now
+	}
+	return true
+}
+
+// Release gives up a reservation after the action failed, so the next
+// attempt for key isn't blocked
+func (c *Cooldown) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.last, key)
+}
+
+// sweep drops expired keys at most once per window so the map doesn't grow
+// with every address ever seen
+func (c *Cooldown) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.window {
+		return
+	}
+	for key, last := range c.last {
+		if now.Sub(last) >= c.window {
+			delete(c.last, key)
+		}
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCooldown(t *testing.T) {
+	c := NewCooldown(50 * time.Millisecond)
+
+	if !c.Reserve("a@example.com") {
+		t.Fatal("first action should be allowed")
+	}
+	if c.Reserve("a@example.com") {
+		t.Fatal("action allowed again within the window")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !c.Reserve("a@example.com") {
+		t.Fatal("action still blocked after the window expired")
+	}
+}
+
+func TestCooldownRelease(t *testing.T) {
+	c := NewCooldown(time.Hour)
+	c.Reserve("a@example.com")
+	c.Release("a@example.com")
+	if !c.Reserve("a@example.com") {
+		t.Fatal("released reservation still blocks the key")
+	}
+}
+
+func TestCooldownReserveIsAtomic(t *testing.T) {
+	c := NewCooldown(time.Hour)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.Reserve("a@example.com") {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 1 {
+		t.Fatalf("%d concurrent reservations granted, want 1", granted)
+	}
+}
+
+func TestCooldownIsPerKey(t *testing.T) {
+	c := NewCooldown(time.Hour)
+	c.Reserve("a@example.com")
+
+	if c.Reserve("a@example.com") {
+		t.Fatal("a@example.com should be in its cooldown window")
+	}
+	if !c.Reserve("b@example.com") {
+		t.Fatal("b@example.com was blocked by a@example.com's cooldown")
+	}
+}
+
+func TestCooldownZeroWindowDisables(t *testing.T) {
+	c := NewCooldown(0)
+	for i := 0; i < 3; i++ {
+		if !c.Reserve("a@example.com") {
+			t.Fatalf("action %d blocked with cooldown disabled", i+1)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// asciiDomain punycode-encodes the domain of addr, leaving the local part as is
+func asciiDomain(addr string) (string, error) {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return addr, nil
+	}
+	domain, err := idna.Lookup.ToASCII(addr[at+1:])
+	if err != nil {
+		return "", fmt.Errorf("invalid domain in address %q: %w", addr, err)
+	}
+	return addr[:at+1] + domain, nil
+}
+
+// headerAddress returns addr with an ASCII domain for use in message headers,
+// falling back to addr unchanged if the domain cannot be encoded
+func headerAddress(addr string) string {
+	if encoded, err := asciiDomain(addr); err == nil {
+		return encoded
+	}
+	return addr
+}
+
+// envelopeAddress returns addr in the form to use for MAIL FROM and RCPT TO.
+// Servers that support SMTPUTF8 take internationalized addresses as is;
+// otherwise the domain is punycode-encoded, and an address whose local part
+// is still non-ASCII cannot be delivered.
+func envelopeAddress(addr string, smtputf8 bool) (string, error) {
+	if smtputf8 || isASCII(addr) {
+		return addr, nil
+	}
+	encoded, err := asciiDomain(addr)
+	if err != nil {
+		return "", err
+	}
+	if !isASCII(encoded) {
+		return "", fmt.Errorf("address %q requires SMTPUTF8, which the server does not support", addr)
+	}
+	return encoded, nil
+}
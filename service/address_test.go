@@ -0,0 +1,85 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dhawalhost/leapmailr/models"
+)
+
+func TestEnvelopeAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		smtputf8 bool
+		want     string
+		wantErr  bool
+	}{
+		{"x@example.com", false, "x@example.com", false},
+		{"x@münchen.de", false, "x@xn--mnchen-3ya.de", false},
+		{"ü@münchen.de", false, "", true},
+		{"x@münchen.de", true, "x@münchen.de", false},
+		{"ü@münchen.de", true, "ü@münchen.de", false},
+	}
+	for _, tt := range tests {
+		got, err := envelopeAddress(tt.addr, tt.smtputf8)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("envelopeAddress(%q, %v) error = %v, wantErr %v", tt.addr, tt.smtputf8, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("envelopeAddress(%q, %v) = %q, want %q", tt.addr, tt.smtputf8, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderAddress(t *testing.T) {
+	if got := headerAddress("ü@münchen.de"); got != "ü@xn--mnchen-3ya.de" {
+		t.Fatalf("headerAddress = %q", got)
+	}
+}
+
+func TestSendMailInternationalizedRecipient(t *testing.T) {
+	tests := []struct {
+		name         string
+		recipient    string
+		smtputf8     bool
+		wantErr      bool
+		wantEnvelope []string
+	}{
+		{
+			name:         "punycode without SMTPUTF8",
+			recipient:    "x@münchen.de",
+			wantEnvelope: []string{"MAIL FROM:<co@example.com>", "RCPT TO:<x@xn--mnchen-3ya.de>"},
+		},
+		{
+			name:         "raw address with SMTPUTF8",
+			recipient:    "x@münchen.de",
+			smtputf8:     true,
+			wantEnvelope: []string{"MAIL FROM:<co@example.com> SMTPUTF8", "RCPT TO:<x@münchen.de>"},
+		},
+		{
+			// Rejected before MAIL, so the server sees no envelope at all
+			name:      "non-ASCII local part without SMTPUTF8",
+			recipient: "ü@münchen.de",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubSMTP(t)
+			stub.smtputf8 = tt.smtputf8
+
+			sender := models.Sender{Name: "Co", Email: "co@example.com"}
+			recipient := models.Recipient{Email: tt.recipient}
+			_, err := sendMailWithRetry(sender, recipient, mailHeaders(sender, recipient, "hi"), "<p>hi</p>", stub.details())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := stub.envelopeCommands()
+			if strings.Join(got, "\n") != strings.Join(tt.wantEnvelope, "\n") {
+				t.Fatalf("envelope = %q, want %q", got, tt.wantEnvelope)
+			}
+		})
+	}
+}
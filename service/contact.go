@@ -2,14 +2,18 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"net/smtp"
+	"html/template"
 	"os"
 	"strings"
-	"text/template"
+	"sync"
+	"time"
 
 	"github.com/dhawalhost/leapmailr/config"
 	"github.com/dhawalhost/leapmailr/models"
+	"github.com/dhawalhost/leapmailr/ratelimit"
+	"github.com/dhawalhost/leapmailr/templatefuncs"
 )
 
 var (
@@ -17,177 +21,165 @@ var (
 	contact_us_template       = "./templates/contact_us_template.html"
 )
 
-func SubmitForm(sender models.Sender, recipient models.Recipient, form models.ContactForm, smtpServer models.SMTPDetails) (err error) {
-	if form.Subject == "" {
-		sb := strings.Builder{}
-		subject := "Contact Us Submission from "
-		sb.WriteString(subject)
-		sb.WriteString(form.Name)
-		form.Subject = sb.String()
+var (
+	// replyCooldown limits auto-replies to one per address per window, so the form
+	// can't be used to flood a third party's inbox or bounce replies back and forth
+	replyCooldown     *ratelimit.Cooldown
+	replyCooldownOnce sync.Once
+)
+
+// autoReplyCooldown builds replyCooldown on first use, once the config is loaded
+func autoReplyCooldown() *ratelimit.Cooldown {
+	replyCooldownOnce.Do(func() {
+		window := time.Duration(config.GetConfig().AutoReplyCooldownMinutes) * time.Minute
+		replyCooldown = ratelimit.NewCooldown(window)
+	})
+	return replyCooldown
+}
+
+// automatedLocalParts are mailbox names that belong to machines, not people
+var automatedLocalParts = []string{"noreply", "no-reply", "no_reply", "donotreply", "do-not-reply", "mailer-daemon", "postmaster", "bounce", "bounces"}
+
+// replyKey normalizes an address for the own-address checks and the cooldown
+func replyKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// skipAutoReply returns why an auto-reply to email should not be sent, or an
+// empty string when it may be sent. In that case the address's cooldown window
+// has been reserved; release it if the reply then fails.
+func skipAutoReply(email string, conf config.AppConfig, cooldown *ratelimit.Cooldown) string {
+	email = replyKey(email)
+	if email == replyKey(conf.ContactMail) || email == replyKey(conf.DefaultSenderMail) {
+		return "recipient is our own address"
 	}
 
-	htmlTemplate, err := os.ReadFile(contact_us_template)
-	if err != nil {
-		fmt.Println("Error reading HTML file:", err)
-		return
+	localPart := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		localPart = email[:at]
+	}
+	for _, automated := range automatedLocalParts {
+		if localPart == automated {
+			return "recipient is an automated mailbox"
+		}
 	}
 
-	data := models.ContactUsData{
-		Name:    form.Name,
-		Email:   form.Email,
-		Subject: form.Subject,
-		Message: form.Message,
-		Logo:    config.GetConfig().LogoURL,
+	if !cooldown.Reserve(email) {
+		return "recipient already received an auto-reply recently"
 	}
+	return ""
+}
 
-	tpl, err := template.New("emailTemplate").Parse(string(htmlTemplate))
+// renderTemplate executes the HTML email template at path with data.
+// html/template escapes every submitted value for its context.
+func renderTemplate(path string, data interface{}) (string, error) {
+	htmlTemplate, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Error reading HTML file:", err)
+		return "", err
+	}
+
+	tpl, err := template.New("emailTemplate").Funcs(template.FuncMap(templatefuncs.FuncMap())).Parse(string(htmlTemplate))
 	if err != nil {
 		fmt.Println("Error parsing template:", err)
-		return
+		return "", err
 	}
 
 	var tplBuffer bytes.Buffer
 	if err = tpl.Execute(&tplBuffer, data); err != nil {
 		fmt.Println("Error executing template:", err)
-		return
+		return "", err
 	}
-	htmlContent := tplBuffer.String()
-
-	auth := smtp.CRAMMD5Auth(smtpServer.Email, smtpServer.Secret)
+	return tplBuffer.String(), nil
+}
 
-	smtpAddr := fmt.Sprintf("%s:%d", smtpServer.Server, smtpServer.Port)
-	client, err := smtp.Dial(smtpAddr)
-	if err != nil {
-		fmt.Println("Failed to connect to the SMTP server:", err)
-		return
+func SubmitForm(sender models.Sender, recipient models.Recipient, form models.ContactForm, smtpServer models.SMTPDetails) (err error) {
+	if form.Subject == "" {
+		sb := strings.Builder{}
+		subject := "Contact Us Submission from "
+		sb.WriteString(subject)
+		sb.WriteString(form.Name)
+		form.Subject = sb.String()
 	}
-	defer client.Close()
 
-	if err = client.Auth(auth); err != nil {
-		fmt.Println("Authentication error:", err)
-		return
+	data := models.ContactUsData{
+		Name:    form.Name,
+		Email:   form.Email,
+		Subject: form.Subject,
+		Message: form.Message,
+		Logo:    config.GetConfig().LogoURL,
 	}
 
-	if err = client.Mail(sender.Email); err != nil {
-		fmt.Println("Error setting sender:", err)
-		return
-	}
-	if err = client.Rcpt(recipient.Email); err != nil {
-		fmt.Println("Error setting recipient:", err)
+	htmlContent, err := renderTemplate(contact_us_template, data)
+	if err != nil {
 		return
 	}
 
-	headers := make(map[string]string)
-	headers["From"] = fmt.Sprintf("%s <%s>", sender.Name, sender.Email)
-	headers["To"] = recipient.Email
-	headers["Subject"] = form.Subject
-	headers["MIME-Version"] = "1.0"
-	headers["Content-Type"] = "text/html; charset=utf-8"
-
-	var emailBuffer bytes.Buffer
-	for key, value := range headers {
-		emailBuffer.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
-	}
-	emailBuffer.WriteString("\r\n")
-	emailBuffer.WriteString(htmlContent)
+	headers := mailHeaders(sender, recipient, form.Subject)
 
-	w, err := client.Data()
-	if err != nil {
-		fmt.Println("Error preparing data:", err)
+	if err = validateHeaders(headers); err != nil {
+		fmt.Println("Error validating headers:", err)
 		return
 	}
-	defer w.Close()
 
-	_, err = w.Write(emailBuffer.Bytes())
+	attempts, err := sendMailWithRetry(sender, recipient, headers, htmlContent, smtpServer)
 	if err != nil {
-		fmt.Println("Error writing message:", err)
 		return
 	}
 
-	fmt.Println("Email sent successfully!")
+	fmt.Println("Email sent successfully! Attempts:", attempts)
 	return
 }
 
-func SendReply(sender models.Sender, recipient models.Recipient, smtpServer models.SMTPDetails) (err error) {
-	subject := "Thank you for Contacting Us!"
-
-	htmlTemplate, err := os.ReadFile(contact_us_reply_template)
-	if err != nil {
-		fmt.Println("Error reading HTML file:", err)
-		return
-	}
-
-	data := models.ContactReplyData{
+// replyData fills the auto-reply template from the deployment's configuration
+func replyData(sender models.Sender, recipient models.Recipient, conf config.AppConfig) models.ContactReplyData {
+	return models.ContactReplyData{
 		RecipientName: recipient.Name,
 		SenderName:    sender.Name,
-		Logo:          config.GetConfig().LogoURL,
-		MailTo:        config.GetConfig().ContactMail,
-	}
-
-	tpl, err := template.New("emailTemplate").Parse(string(htmlTemplate))
-	if err != nil {
-		fmt.Println("Error parsing template:", err)
-		return
+		Logo:          conf.LogoURL,
+		MailTo:        conf.ContactMail,
+		WebsiteURL:    conf.WebsiteURL,
+		SupportPhone:  conf.SupportPhone,
+		ResponseTime:  conf.ResponseTime,
 	}
+}
 
-	var tplBuffer bytes.Buffer
-	if err = tpl.Execute(&tplBuffer, data); err != nil {
-		fmt.Println("Error executing template:", err)
+func SendReply(sender models.Sender, recipient models.Recipient, smtpServer models.SMTPDetails) (err error) {
+	conf := config.GetConfig()
+	cooldown := autoReplyCooldown()
+	if reason := skipAutoReply(recipient.Email, conf, cooldown); reason != "" {
+		fmt.Println("Skipping auto-reply to", recipient.Email+":", reason)
 		return
 	}
-	htmlContent := tplBuffer.String()
+	defer func() {
+		// Keep the window when the reply may have arrived despite the error
+		var unknown *deliveryUnknownError
+		if err != nil && !errors.As(err, &unknown) {
+			cooldown.Release(replyKey(recipient.Email))
+		}
+	}()
 
-	auth := smtp.CRAMMD5Auth(smtpServer.Email, smtpServer.Secret)
+	subject := "Thank you for Contacting Us!"
 
-	smtpAddr := fmt.Sprintf("%s:%d", smtpServer.Server, smtpServer.Port)
-	client, err := smtp.Dial(smtpAddr)
+	htmlContent, err := renderTemplate(contact_us_reply_template, replyData(sender, recipient, conf))
 	if err != nil {
-		fmt.Println("Failed to connect to the SMTP server:", err)
 		return
 	}
-	defer client.Close()
 
-	if err = client.Auth(auth); err != nil {
-		fmt.Println("Authentication error:", err)
-		return
-	}
-
-	if err = client.Mail(sender.Email); err != nil {
-		fmt.Println("Error setting sender:", err)
-		return
-	}
-	if err = client.Rcpt(recipient.Email); err != nil {
-		fmt.Println("Error setting recipient:", err)
-		return
-	}
-
-	headers := make(map[string]string)
-	headers["From"] = fmt.Sprintf("%s <%s>", sender.Name, sender.Email)
-	headers["To"] = recipient.Email
-	headers["Subject"] = subject
-	headers["MIME-Version"] = "1.0"
-	headers["Content-Type"] = "text/html; charset=utf-8"
+	headers := mailHeaders(sender, recipient, subject)
+	// RFC 3834: tells other responders not to answer this message
+	headers["Auto-Submitted"] = "auto-replied"
 
-	var emailBuffer bytes.Buffer
-	for key, value := range headers {
-		emailBuffer.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
-	}
-	emailBuffer.WriteString("\r\n")
-	emailBuffer.WriteString(htmlContent)
-
-	w, err := client.Data()
-	if err != nil {
-		fmt.Println("Error preparing data:", err)
+	if err = validateHeaders(headers); err != nil {
+		fmt.Println("Error validating headers:", err)
 		return
 	}
-	defer w.Close()
 
-	_, err = w.Write(emailBuffer.Bytes())
+	attempts, err := sendMailWithRetry(sender, recipient, headers, htmlContent, smtpServer)
 	if err != nil {
-		fmt.Println("Error writing message:", err)
 		return
 	}
 
-	fmt.Println("Email sent successfully!")
+	fmt.Println("Email sent successfully! Attempts:", attempts)
 	return
 }
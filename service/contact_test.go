@@ -0,0 +1,188 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhawalhost/leapmailr/config"
+	"github.com/dhawalhost/leapmailr/models"
+	"github.com/dhawalhost/leapmailr/ratelimit"
+)
+
+func TestSubmitFormRejectsHeaderInjectionWithoutDialing(t *testing.T) {
+	defer func(path string) { contact_us_template = path }(contact_us_template)
+	contact_us_template = "../templates/contact_us_template.html"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	form := models.ContactForm{
+		Name:    "Mallory",
+		Email:   "mallory@example.com",
+		Subject: "hi\r\nBcc: evil@x.com",
+		Message: "hello",
+	}
+	smtpServer := models.SMTPDetails{
+		Server:  "127.0.0.1",
+		Port:    ln.Addr().(*net.TCPAddr).Port,
+		Timeout: 100 * time.Millisecond,
+	}
+	err = SubmitForm(models.Sender{Name: "Co", Email: "co@example.com"}, models.Recipient{Email: "inbox@example.com"}, form, smtpServer)
+	if err == nil {
+		t.Fatal("expected an error for a subject containing CRLF")
+	}
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(50 * time.Millisecond))
+	if conn, err := ln.Accept(); err == nil {
+		conn.Close()
+		t.Fatal("SubmitForm connected to the SMTP server despite invalid headers")
+	}
+}
+
+func TestContactTemplateEscapesSubmittedValues(t *testing.T) {
+	payload := "<script>alert(1)</script>"
+	html, err := renderTemplate("../templates/contact_us_template.html", models.ContactUsData{
+		Name:    payload,
+		Email:   "mallory@example.com",
+		Subject: payload,
+		Message: payload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(html, payload) {
+		t.Fatal("rendered template contains an unescaped <script> tag")
+	}
+	if !strings.Contains(html, "&lt;script&gt;") {
+		t.Fatal("rendered template does not contain the escaped payload")
+	}
+}
+
+func TestReplyTemplateUsesConfiguredValues(t *testing.T) {
+	conf := config.AppConfig{
+		ContactMail:  "hello@acme.example",
+		LogoURL:      "https://acme.example/logo.png",
+		WebsiteURL:   "https://acme.example",
+		SupportPhone: "+1 555 0100",
+		ResponseTime: "2 business days",
+	}
+	data := replyData(models.Sender{Name: "Acme"}, models.Recipient{Name: "Ada"}, conf)
+	html, err := renderTemplate("../templates/contact_us_reply_template.html", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{conf.ContactMail, conf.LogoURL, conf.WebsiteURL, "&#43;1 555 0100", "within " + conf.ResponseTime, "Ada"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered auto-reply is missing %q", want)
+		}
+	}
+	if strings.Contains(html, "dhawalhost") {
+		t.Error("rendered auto-reply still contains the built-in dhawalhost branding")
+	}
+}
+
+func TestSkipAutoReply(t *testing.T) {
+	conf := config.AppConfig{ContactMail: "Hello@Acme.example", DefaultSenderMail: "mailer@acme.example"}
+	cooldown := ratelimit.NewCooldown(time.Hour)
+	cooldown.Reserve("recent@example.com")
+
+	tests := []struct {
+		email string
+		skip  bool
+	}{
+		{"ada@example.com", false},
+		{"noreply@example.com", true},
+		{"No-Reply@example.com", true},
+		{"mailer-daemon@example.com", true},
+		{"hello@acme.example", true},
+		{" MAILER@acme.example ", true},
+		{"recent@example.com", true},
+	}
+	for _, tt := range tests {
+		if reason := skipAutoReply(tt.email, conf, cooldown); (reason != "") != tt.skip {
+			t.Errorf("skipAutoReply(%q) = %q, want skip %v", tt.email, reason, tt.skip)
+		}
+	}
+}
+
+// useReplyTemplate points SendReply at the reply template and gives it a fresh
+// one-hour cooldown in place of the one built from the config
+func useReplyTemplate(t *testing.T) {
+	path := contact_us_reply_template
+	t.Cleanup(func() { contact_us_reply_template = path })
+	contact_us_reply_template = "../templates/contact_us_reply_template.html"
+
+	replyCooldownOnce.Do(func() {})
+	replyCooldown = ratelimit.NewCooldown(time.Hour)
+}
+
+func TestSendReplyReleasesCooldownOnFailure(t *testing.T) {
+	useReplyTemplate(t)
+	stub := newStubSMTP(t)
+	stub.rcptReplies = []string{"550 5.1.1 no such user"}
+	sender := models.Sender{Name: "Co", Email: "co@example.com"}
+	recipient := models.Recipient{Name: "Ada", Email: "ada@example.com"}
+
+	if err := SendReply(sender, recipient, stub.details()); err == nil {
+		t.Fatal("expected the rejected reply to fail")
+	}
+	// The failed reply must not block the next one
+	if err := SendReply(sender, recipient, stub.details()); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendReply(sender, recipient, stub.details()); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.messageCount(); got != 1 {
+		t.Fatalf("server received %d replies, want 1", got)
+	}
+}
+
+func TestSendReplyConcurrentSubmissionsSendOnce(t *testing.T) {
+	useReplyTemplate(t)
+	stub := newStubSMTP(t)
+	sender := models.Sender{Name: "Co", Email: "co@example.com"}
+	recipient := models.Recipient{Name: "Ada", Email: "ada@example.com"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := SendReply(sender, recipient, stub.details()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := stub.messageCount(); got != 1 {
+		t.Fatalf("server received %d replies to concurrent submissions, want 1", got)
+	}
+}
+
+func TestSendReplyKeepsCooldownWhenDeliveryUnknown(t *testing.T) {
+	useReplyTemplate(t)
+	stub := newStubSMTP(t)
+	stub.hangAfterData = true
+	smtpServer := stub.details()
+	smtpServer.Timeout = 100 * time.Millisecond
+	sender := models.Sender{Name: "Co", Email: "co@example.com"}
+	recipient := models.Recipient{Name: "Ada", Email: "ada@example.com"}
+
+	if err := SendReply(sender, recipient, smtpServer); err == nil {
+		t.Fatal("expected an error when the server never confirms the message")
+	}
+	// The reply may have been delivered, so a second one must be skipped
+	if err := SendReply(sender, recipient, smtpServer); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.messageCount(); got != 1 {
+		t.Fatalf("server received %d replies, want 1", got)
+	}
+}
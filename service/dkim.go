@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/dhawalhost/leapmailr/models"
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimHeaderKeys are the headers covered by the signature. Listing a header
+// the message lacks is allowed and stops it from being added later.
+var dkimHeaderKeys = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// signDKIM returns the message with a DKIM-Signature header prepended, or the
+// message unchanged when no signing key is configured
+func signDKIM(message []byte, options models.DKIMOptions) ([]byte, error) {
+	if options.PrivateKeyFile == "" {
+		return message, nil
+	}
+	if options.Domain == "" || options.Selector == "" {
+		return nil, errors.New("dkim: domain and selector are required with a private key")
+	}
+
+	signer, err := cachedDKIMKey(options.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the message as it will travel: the DATA writer turns bare LF into CRLF
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, bytes.NewReader(toCRLF(message)), &dkim.SignOptions{
+		Domain:                 options.Domain,
+		Selector:               options.Selector,
+		Signer:                 signer,
+		HeaderKeys:             dkimHeaderKeys,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signed.Bytes(), nil
+}
+
+// toCRLF converts bare LF line endings to CRLF, leaving existing CRLF intact
+func toCRLF(message []byte) []byte {
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(message, []byte("\n"), []byte("\r\n"))
+}
+
+var (
+	dkimKeysMu sync.Mutex
+	dkimKeys   = make(map[string]crypto.Signer)
+)
+
+// cachedDKIMKey loads the key at path on first use and reuses it afterwards.
+// Failed loads aren't cached, so a key file restored after a deploy or
+// rotation is picked up by the next send.
+func cachedDKIMKey(path string) (crypto.Signer, error) {
+	dkimKeysMu.Lock()
+	defer dkimKeysMu.Unlock()
+
+	if signer, ok := dkimKeys[path]; ok {
+		return signer, nil
+	}
+	signer, err := loadDKIMKey(path)
+	if err != nil {
+		return nil, err
+	}
+	dkimKeys[path] = signer
+	return signer, nil
+}
+
+// loadDKIMKey reads an RSA or Ed25519 private key in PKCS#1 or PKCS#8 PEM form
+func loadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("dkim: no PEM block found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: parsing private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("dkim: unsupported private key type %T", key)
+	}
+	return signer, nil
+}
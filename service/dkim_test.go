@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dhawalhost/leapmailr/models"
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+func writeEd25519Key(t *testing.T) (string, ed25519.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, pub
+}
+
+func TestSignDKIMVerifies(t *testing.T) {
+	keyFile, pub := writeEd25519Key(t)
+
+	headers := mailHeaders(models.Sender{Name: "Co", Email: "co@example.com"}, models.Recipient{Email: "someone@example.com"}, "hi")
+	var message bytes.Buffer
+	writeHeaders(&message, headers)
+	message.WriteString("\r\n")
+	// Rendered templates use bare LF line endings
+	message.WriteString("<p>line one</p>\n<p>line two</p>\n")
+
+	signed, err := signDKIM(message.Bytes(), models.DKIMOptions{Domain: "example.com", Selector: "mail", PrivateKeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(signed), "DKIM-Signature:") {
+		t.Fatalf("signed message does not start with a DKIM-Signature header:\n%s", signed)
+	}
+	if bytes.Contains(bytes.ReplaceAll(signed, []byte("\r\n"), nil), []byte("\n")) {
+		t.Fatal("signed message still contains bare LF line endings")
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(signed), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "mail._domainkey.example.com" {
+				t.Errorf("unexpected TXT lookup for %s", domain)
+			}
+			return []string{"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 1 || verifications[0].Err != nil {
+		t.Fatalf("verifications = %+v", verifications)
+	}
+}
+
+func TestSignDKIMWithoutKeyLeavesMessageUnchanged(t *testing.T) {
+	message := []byte("Subject: hi\r\n\r\nbody\n")
+	signed, err := signDKIM(message, models.DKIMOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signed, message) {
+		t.Fatalf("got %q, want the message unchanged", signed)
+	}
+}
+
+func TestCachedDKIMKeyLoadsOnce(t *testing.T) {
+	keyFile, _ := writeEd25519Key(t)
+	if _, err := cachedDKIMKey(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedDKIMKey(keyFile); err != nil {
+		t.Fatalf("second load read the file again: %v", err)
+	}
+}
+
+func TestCachedDKIMKeyRetriesFailedLoads(t *testing.T) {
+	keyFile, _ := writeEd25519Key(t)
+	pemData, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "rotated.pem")
+	if _, err := cachedDKIMKey(missing); err == nil {
+		t.Fatal("expected an error for a missing key file")
+	}
+	if err := os.WriteFile(missing, pemData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedDKIMKey(missing); err != nil {
+		t.Fatalf("key restored after a failed load was not picked up: %v", err)
+	}
+}
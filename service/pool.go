@@ -0,0 +1,141 @@
+package service
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/dhawalhost/leapmailr/models"
+)
+
+const (
+	// poolMaxIdleTime stays below the idle timeout of common SMTP servers
+	poolMaxIdleTime = 30 * time.Second
+	poolMaxLifetime = 5 * time.Minute
+)
+
+// pooledConn is an authenticated SMTP connection that can carry several messages
+type pooledConn struct {
+	client   *smtp.Client
+	conn     net.Conn
+	created  time.Time
+	lastUsed time.Time
+}
+
+// connPool keeps idle authenticated SMTP connections per server and account so
+// consecutive sends skip the dial, TLS and AUTH round trips. It is safe for
+// concurrent use; each connection is handed to one caller at a time.
+type connPool struct {
+	mu          sync.Mutex
+	idle        map[string][]*pooledConn
+	maxIdle     time.Duration
+	maxLifetime time.Duration
+}
+
+var smtpPool = newConnPool(poolMaxIdleTime, poolMaxLifetime)
+
+func newConnPool(maxIdle, maxLifetime time.Duration) *connPool {
+	return &connPool{
+		idle:        make(map[string][]*pooledConn),
+		maxIdle:     maxIdle,
+		maxLifetime: maxLifetime,
+	}
+}
+
+// poolKey identifies the server and account a connection is authenticated for.
+// The secret is hashed so it never sits in memory as part of a map key.
+func poolKey(smtpServer models.SMTPDetails) string {
+	secret := sha256.Sum256([]byte(smtpServer.Secret))
+	return fmt.Sprintf("%s|%d|%s|%s|%x", smtpServer.Server, smtpServer.Port, smtpServer.AuthType, smtpServer.Email, secret)
+}
+
+// get returns a live idle connection for the server, resetting it with RSET,
+// or opens a fresh one when none is usable
+func (p *connPool) get(smtpServer models.SMTPDetails) (*pooledConn, error) {
+	key := poolKey(smtpServer)
+	for {
+		pc := p.pop(key)
+		if pc == nil {
+			return openSMTPConn(smtpServer)
+		}
+		if p.expired(pc, time.Now()) {
+			pc.client.Close()
+			continue
+		}
+		// RSET clears any leftover transaction state and doubles as a liveness check
+		if err := pc.conn.SetDeadline(smtpDeadline(smtpServer)); err != nil || pc.client.Reset() != nil {
+			pc.client.Close()
+			continue
+		}
+		return pc, nil
+	}
+}
+
+// put returns a healthy connection to the pool, or closes it when pooling is
+// disabled or the pool for that server is full
+func (p *connPool) put(smtpServer models.SMTPDetails, pc *pooledConn) {
+	key := poolKey(smtpServer)
+	pc.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if len(p.idle[key]) < smtpServer.PoolSize {
+		p.idle[key] = append(p.idle[key], pc)
+		p.mu.Unlock()
+		// Close the connection if it is still idle when the limit runs out,
+		// instead of holding the socket until the next send
+		time.AfterFunc(p.maxIdle, func() { p.reap(key) })
+		return
+	}
+	p.mu.Unlock()
+
+	pc.client.Quit()
+	pc.client.Close()
+}
+
+// expired reports whether pc has been idle or open for too long to reuse
+func (p *connPool) expired(pc *pooledConn, now time.Time) bool {
+	return now.Sub(pc.created) > p.maxLifetime || now.Sub(pc.lastUsed) >= p.maxIdle
+}
+
+// reap closes the idle connections for key that are past their limits
+func (p *connPool) reap(key string) {
+	now := time.Now()
+	var stale []*pooledConn
+
+	p.mu.Lock()
+	live := p.idle[key][:0]
+	for _, pc := range p.idle[key] {
+		if p.expired(pc, now) {
+			stale = append(stale, pc)
+		} else {
+			live = append(live, pc)
+		}
+	}
+	if len(live) == 0 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = live
+	}
+	p.mu.Unlock()
+
+	for _, pc := range stale {
+		pc.client.Close()
+	}
+}
+
+// pop removes and returns the most recently used idle connection for key
+func (p *connPool) pop(key string) *pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	pc := conns[len(conns)-1]
+	p.idle[key] = conns[:len(conns)-1]
+	return pc
+}
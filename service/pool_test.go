@@ -0,0 +1,113 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhawalhost/leapmailr/models"
+)
+
+func TestPoolReusesConnection(t *testing.T) {
+	stub := newStubSMTP(t)
+	smtpServer := stub.details()
+	smtpServer.PoolSize = 2
+
+	for i := 0; i < 2; i++ {
+		if _, err := testSend(smtpServer); err != nil {
+			t.Fatalf("send %d: %v", i+1, err)
+		}
+	}
+	if got := stub.connections(); got != 1 {
+		t.Fatalf("server accepted %d connections, want 1", got)
+	}
+	if got := stub.messageCount(); got != 2 {
+		t.Fatalf("server received %d messages, want 2", got)
+	}
+}
+
+func TestPoolReplacesDeadConnection(t *testing.T) {
+	stub := newStubSMTP(t)
+	smtpServer := stub.details()
+	smtpServer.PoolSize = 2
+
+	if _, err := testSend(smtpServer); err != nil {
+		t.Fatal(err)
+	}
+	// The pooled connection now fails RSET, so the next send must dial again
+	stub.dropConnections()
+
+	attempts, err := testSend(smtpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+	if got := stub.connections(); got != 2 {
+		t.Fatalf("server accepted %d connections, want 2", got)
+	}
+}
+
+func TestPoolKeyHashesSecret(t *testing.T) {
+	smtpServer := models.SMTPDetails{Server: "smtp.example.com", Port: 587, Email: "user@example.com", Secret: "hunter2"}
+	key := poolKey(smtpServer)
+	if strings.Contains(key, smtpServer.Secret) {
+		t.Fatalf("pool key %q contains the plaintext secret", key)
+	}
+
+	other := smtpServer
+	other.Secret = "rotated"
+	if poolKey(other) == key {
+		t.Fatal("different secrets produced the same pool key")
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestPoolReapsIdleConnections(t *testing.T) {
+	stub := newStubSMTP(t)
+	smtpServer := stub.details()
+	smtpServer.PoolSize = 1
+	pool := newConnPool(100*time.Millisecond, time.Hour)
+
+	pc, err := pool.get(smtpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.put(smtpServer, pc)
+
+	// Reusing the connection before the limit keeps it open past the first timer
+	time.Sleep(60 * time.Millisecond)
+	if pc, err = pool.get(smtpServer); err != nil {
+		t.Fatal(err)
+	}
+	pool.put(smtpServer, pc)
+	time.Sleep(60 * time.Millisecond)
+	if stub.closedConnections() != 0 {
+		t.Fatal("connection closed while it was still within its idle limit")
+	}
+
+	if !waitFor(t, func() bool { return stub.closedConnections() == 1 }) {
+		t.Fatal("idle connection was never closed")
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.idle) != 0 {
+		t.Fatalf("pool still holds %d idle entries", len(pool.idle))
+	}
+	if stub.connections() != 1 {
+		t.Fatalf("server accepted %d connections, want 1", stub.connections())
+	}
+}
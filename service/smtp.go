@@ -0,0 +1,358 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhawalhost/leapmailr/models"
+	"golang.org/x/net/idna"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var warnInsecureTLS sync.Once
+
+// headerOrder is the canonical order in which known headers are written
+var headerOrder = []string{"Date", "Message-ID", "From", "To", "Subject", "MIME-Version", "Content-Type"}
+
+// retryBaseDelay is the wait before the first retry, doubled on each attempt
+var retryBaseDelay = time.Second
+
+// mailHeaders returns the headers for an HTML message. Date and Message-ID are
+// fixed here so every retry of the message carries the same values.
+func mailHeaders(sender models.Sender, recipient models.Recipient, subject string) map[string]string {
+	return map[string]string{
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   generateMessageID(sender.Email),
+		"From":         fmt.Sprintf("%s <%s>", sender.Name, headerAddress(sender.Email)),
+		"To":           headerAddress(recipient.Email),
+		"Subject":      subject,
+		"MIME-Version": "1.0",
+		"Content-Type": "text/html; charset=utf-8",
+	}
+}
+
+// generateMessageID returns a unique RFC 5322 message id on the sender's domain
+func generateMessageID(senderEmail string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(senderEmail, "@"); at >= 0 && at < len(senderEmail)-1 {
+		if encoded, err := idna.Lookup.ToASCII(senderEmail[at+1:]); err == nil {
+			domain = encoded
+		}
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand does not fail on supported platforms; keep ids unique regardless
+		return fmt.Sprintf("<%d@%s>", time.Now().UnixNano(), domain)
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+}
+
+// writeHeaders writes headers in headerOrder, followed by any others sorted by
+// name, so the same message always serializes identically
+func writeHeaders(buf *bytes.Buffer, headers map[string]string) {
+	written := make(map[string]bool, len(headers))
+	for _, key := range headerOrder {
+		if value, ok := headers[key]; ok {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+			written[key] = true
+		}
+	}
+
+	var rest []string
+	for key := range headers {
+		if !written[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", key, headers[key]))
+	}
+}
+
+// validateHeaders rejects header values containing line breaks, which would
+// otherwise let user input inject extra headers or body content
+func validateHeaders(headers map[string]string) error {
+	for key, value := range headers {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid %s header: value contains a line break", key)
+		}
+	}
+	return nil
+}
+
+// dialSMTP connects to the SMTP server and waits for its greeting, giving up
+// once the configured timeout elapses instead of blocking indefinitely
+func dialSMTP(smtpServer models.SMTPDetails) (client *smtp.Client, conn net.Conn, err error) {
+	smtpAddr := net.JoinHostPort(smtpServer.Server, strconv.Itoa(smtpServer.Port))
+	dialer := net.Dialer{Timeout: smtpServer.Timeout}
+	conn, err = dialer.Dial("tcp", smtpAddr)
+	if err != nil {
+		return
+	}
+	if err = conn.SetDeadline(smtpDeadline(smtpServer)); err != nil {
+		conn.Close()
+		return
+	}
+	client, err = smtp.NewClient(conn, smtpServer.Server)
+	if err != nil {
+		conn.Close()
+	}
+	return
+}
+
+// smtpDeadline returns the deadline for the next exchange with the server,
+// or the zero time (no deadline) when no timeout is configured
+func smtpDeadline(smtpServer models.SMTPDetails) time.Time {
+	if smtpServer.Timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(smtpServer.Timeout)
+}
+
+// smtpAuth returns the SMTP authentication mechanism selected by smtpServer.AuthType
+func smtpAuth(smtpServer models.SMTPDetails) (smtp.Auth, error) {
+	switch strings.ToLower(smtpServer.AuthType) {
+	case "", "cram-md5":
+		return smtp.CRAMMD5Auth(smtpServer.Email, smtpServer.Secret), nil
+	case "plain":
+		return smtp.PlainAuth("", smtpServer.Email, smtpServer.Secret, smtpServer.Server), nil
+	case "xoauth2":
+		token, err := oauthAccessToken(smtpServer.OAuth, smtpServer.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &xoauth2Auth{username: smtpServer.Email, token: token}, nil
+	default:
+		return nil, fmt.Errorf("unsupported SMTP auth type %q", smtpServer.AuthType)
+	}
+}
+
+// tlsConfig builds the STARTTLS configuration from smtpServer.TLS. Certificates
+// are verified against the system roots unless a CA file or skip-verify is set.
+func tlsConfig(smtpServer models.SMTPDetails) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         smtpServer.Server,
+		InsecureSkipVerify: smtpServer.TLS.SkipVerify,
+	}
+	if smtpServer.TLS.SkipVerify {
+		warnInsecureTLS.Do(func() {
+			fmt.Println("Warning: TLS certificate verification is disabled for SMTP server", smtpServer.Server)
+		})
+	}
+
+	if smtpServer.TLS.MinVersion != "" {
+		version, ok := tlsVersions[smtpServer.TLS.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q", smtpServer.TLS.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if smtpServer.TLS.CACertFile != "" {
+		pem, err := os.ReadFile(smtpServer.TLS.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", smtpServer.TLS.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// openSMTPConn dials the server, upgrades to TLS when offered and authenticates
+func openSMTPConn(smtpServer models.SMTPDetails) (pc *pooledConn, err error) {
+	auth, err := smtpAuth(smtpServer)
+	if err != nil {
+		fmt.Println("Authentication error:", err)
+		return
+	}
+
+	client, conn, err := dialSMTP(smtpServer)
+	if err != nil {
+		fmt.Println("Failed to connect to the SMTP server:", err)
+		return
+	}
+
+	// Upgrade to TLS whenever the server offers it, as smtp.SendMail does, so the
+	// message is protected too and not just the credentials
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		var config *tls.Config
+		if config, err = tlsConfig(smtpServer); err != nil {
+			client.Close()
+			fmt.Println("Error configuring TLS:", err)
+			return
+		}
+		if err = client.StartTLS(config); err != nil {
+			client.Close()
+			fmt.Println("Error starting TLS:", err)
+			return
+		}
+	}
+
+	if err = client.Auth(auth); err != nil {
+		client.Close()
+		fmt.Println("Authentication error:", err)
+		return
+	}
+	return &pooledConn{client: client, conn: conn, created: time.Now()}, nil
+}
+
+// sendMail delivers an HTML message to the recipient, reusing a pooled SMTP
+// connection when one is available
+func sendMail(sender models.Sender, recipient models.Recipient, headers map[string]string, htmlContent string, smtpServer models.SMTPDetails) (err error) {
+	pc, err := smtpPool.get(smtpServer)
+	if err != nil {
+		return
+	}
+	defer func() {
+		// A connection that failed mid-transaction is in an unknown state
+		if err != nil {
+			pc.client.Close()
+			return
+		}
+		smtpPool.put(smtpServer, pc)
+	}()
+	client, conn := pc.client, pc.conn
+
+	// net/smtp adds the SMTPUTF8 parameter to MAIL FROM itself when the server supports it
+	smtputf8, _ := client.Extension("SMTPUTF8")
+	from, err := envelopeAddress(sender.Email, smtputf8)
+	if err != nil {
+		fmt.Println("Error setting sender:", err)
+		return
+	}
+	to, err := envelopeAddress(recipient.Email, smtputf8)
+	if err != nil {
+		fmt.Println("Error setting recipient:", err)
+		return
+	}
+
+	if err = client.Mail(from); err != nil {
+		fmt.Println("Error setting sender:", err)
+		return
+	}
+	if err = client.Rcpt(to); err != nil {
+		fmt.Println("Error setting recipient:", err)
+		return
+	}
+
+	var emailBuffer bytes.Buffer
+	writeHeaders(&emailBuffer, headers)
+	emailBuffer.WriteString("\r\n")
+	emailBuffer.WriteString(htmlContent)
+
+	message, err := signDKIM(emailBuffer.Bytes(), smtpServer.DKIM)
+	if err != nil {
+		fmt.Println("Error signing message:", err)
+		return
+	}
+
+	if err = conn.SetDeadline(smtpDeadline(smtpServer)); err != nil {
+		fmt.Println("Error setting connection deadline:", err)
+		return
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		fmt.Println("Error preparing data:", err)
+		return
+	}
+
+	_, err = w.Write(message)
+	if err != nil {
+		w.Close()
+		fmt.Println("Error writing message:", err)
+		return
+	}
+
+	// The server only accepts or rejects the message once the data is closed
+	if err = w.Close(); err != nil {
+		// Without an SMTP reply the server may already have queued the message
+		var protoErr *textproto.Error
+		if !errors.As(err, &protoErr) {
+			err = &deliveryUnknownError{err: err}
+		}
+		fmt.Println("Error finishing message:", err)
+		return
+	}
+	return
+}
+
+// deliveryUnknownError is a failure after the whole message was written but
+// before the server replied; the message may have been queued anyway
+type deliveryUnknownError struct {
+	err error
+}
+
+func (e *deliveryUnknownError) Error() string {
+	return "delivery status unknown: " + e.err.Error()
+}
+
+func (e *deliveryUnknownError) Unwrap() error {
+	return e.err
+}
+
+// sendMailWithRetry calls sendMail, retrying transient failures with
+// exponential backoff up to smtpServer.MaxRetries times. No retry is started
+// that would begin after smtpServer.RetryDeadline. It returns the number of
+// attempts made.
+func sendMailWithRetry(sender models.Sender, recipient models.Recipient, headers map[string]string, htmlContent string, smtpServer models.SMTPDetails) (attempts int, err error) {
+	delay := retryBaseDelay
+	for attempts = 1; ; attempts++ {
+		err = sendMail(sender, recipient, headers, htmlContent, smtpServer)
+		if err == nil || attempts > smtpServer.MaxRetries || !isTransientSMTPError(err) {
+			return
+		}
+		if !smtpServer.RetryDeadline.IsZero() && time.Now().Add(delay).After(smtpServer.RetryDeadline) {
+			fmt.Println("Retry budget exhausted after attempt", attempts)
+			return
+		}
+		fmt.Printf("Transient SMTP error on attempt %d, retrying in %v\n", attempts, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientSMTPError reports whether err is worth retrying: 4xx SMTP replies
+// such as greylisting, network timeouts and temporary DNS failures. Permanent
+// 5xx rejections and failures after the message was fully written are not retried.
+func isTransientSMTPError(err error) bool {
+	var unknown *deliveryUnknownError
+	if errors.As(err, &unknown) {
+		return false
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
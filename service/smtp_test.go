@@ -0,0 +1,521 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhawalhost/leapmailr/models"
+)
+
+// stubSMTP is a minimal scripted SMTP server that accepts CRAM-MD5 logins
+type stubSMTP struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	accepted int
+	closed   int
+	messages []string
+	// rcptReplies are the replies to successive RCPT commands; "250 OK" once used up
+	rcptReplies []string
+	// hangAfterData leaves the final "." of a message unanswered
+	hangAfterData bool
+	// smtputf8 makes the server advertise the SMTPUTF8 extension
+	smtputf8 bool
+	// envelope holds the MAIL and RCPT commands received, in order
+	envelope []string
+	// tls, when set, makes the server offer STARTTLS with this configuration
+	tls      *tls.Config
+	tlsConns int
+}
+
+func newStubSMTP(t *testing.T) *stubSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubSMTP{ln: ln}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.dropConnections()
+	})
+	return s
+}
+
+func (s *stubSMTP) details() models.SMTPDetails {
+	return models.SMTPDetails{
+		Server:  "127.0.0.1",
+		Port:    s.ln.Addr().(*net.TCPAddr).Port,
+		Email:   "user@example.com",
+		Secret:  "secret",
+		Timeout: time.Second,
+	}
+}
+
+func (s *stubSMTP) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.accepted++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// connections returns how many connections the server has accepted
+func (s *stubSMTP) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// closedConnections returns how many connections have ended
+func (s *stubSMTP) closedConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// tlsConnections returns how many connections were upgraded with STARTTLS
+func (s *stubSMTP) tlsConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tlsConns
+}
+
+func (s *stubSMTP) messageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+// dropConnections closes every open connection from the server side
+func (s *stubSMTP) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *stubSMTP) nextRcptReply() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.rcptReplies) == 0 {
+		return "250 OK"
+	}
+	reply := s.rcptReplies[0]
+	s.rcptReplies = s.rcptReplies[1:]
+	return reply
+}
+
+func (s *stubSMTP) recordEnvelope(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.envelope = append(s.envelope, line)
+}
+
+func (s *stubSMTP) envelopeCommands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.envelope...)
+}
+
+func (s *stubSMTP) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		s.closed++
+		s.mu.Unlock()
+	}()
+	text := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			text.PrintfLine("%s", line)
+		}
+	}
+
+	reply("220 stub ESMTP")
+	secure := false
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO":
+			lines := []string{"250-stub"}
+			if s.tls != nil && !secure {
+				lines = append(lines, "250-STARTTLS")
+			}
+			if s.smtputf8 {
+				lines = append(lines, "250-SMTPUTF8")
+			}
+			reply(append(lines, "250 AUTH CRAM-MD5")...)
+		case "STARTTLS":
+			if s.tls == nil || secure {
+				reply("502 unrecognized command")
+				continue
+			}
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			secure = true
+			text = textproto.NewConn(tlsConn)
+			s.mu.Lock()
+			s.tlsConns++
+			s.mu.Unlock()
+		case "AUTH":
+			reply("334 PDEyMzQ1QHN0dWI+")
+			if _, err := text.ReadLine(); err != nil {
+				return
+			}
+			reply("235 OK")
+		case "MAIL":
+			s.recordEnvelope(line)
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "RCPT":
+			s.recordEnvelope(line)
+			reply(s.nextRcptReply())
+		case "DATA":
+			reply("354 go ahead")
+			body, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(body))
+			hang := s.hangAfterData
+			s.mu.Unlock()
+			if !hang {
+				reply("250 queued")
+			}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unrecognized command")
+		}
+	}
+}
+
+// shortRetryDelay makes retries back off in milliseconds for the test
+func shortRetryDelay(t *testing.T) {
+	delay := retryBaseDelay
+	t.Cleanup(func() { retryBaseDelay = delay })
+	retryBaseDelay = time.Millisecond
+}
+
+func testSend(smtpServer models.SMTPDetails) (int, error) {
+	sender := models.Sender{Name: "Co", Email: "co@example.com"}
+	recipient := models.Recipient{Email: "someone@example.com"}
+	return sendMailWithRetry(sender, recipient, mailHeaders(sender, recipient, "hi"), "<p>hi</p>", smtpServer)
+}
+
+func TestSendMailWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		rcptReplies  []string
+		maxRetries   int
+		deadline     time.Duration
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"transient twice then success", []string{"451 4.7.1 greylisted", "451 4.7.1 greylisted"}, 3, 0, 3, false},
+		{"permanent failure", []string{"550 5.1.1 no such user"}, 3, 0, 1, true},
+		{"retries exhausted", []string{"451 a", "451 b", "451 c"}, 1, 0, 2, true},
+		{"retry deadline passed", []string{"451 4.7.1 greylisted"}, 3, -time.Second, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shortRetryDelay(t)
+			stub := newStubSMTP(t)
+			stub.rcptReplies = tt.rcptReplies
+
+			smtpServer := stub.details()
+			smtpServer.MaxRetries = tt.maxRetries
+			if tt.deadline != 0 {
+				smtpServer.RetryDeadline = time.Now().Add(tt.deadline)
+			}
+
+			attempts, err := testSend(smtpServer)
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if wantMessages := map[bool]int{false: 1, true: 0}[tt.wantErr]; stub.messageCount() != wantMessages {
+				t.Errorf("server received %d messages, want %d", stub.messageCount(), wantMessages)
+			}
+		})
+	}
+}
+
+func TestSendMailWithRetryDoesNotResendAfterUnansweredData(t *testing.T) {
+	shortRetryDelay(t)
+	stub := newStubSMTP(t)
+	stub.hangAfterData = true
+
+	smtpServer := stub.details()
+	smtpServer.Timeout = 100 * time.Millisecond
+	smtpServer.MaxRetries = 3
+
+	attempts, err := testSend(smtpServer)
+	var unknown *deliveryUnknownError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("got error %v, want a deliveryUnknownError", err)
+	}
+	if attempts != 1 || stub.messageCount() != 1 {
+		t.Fatalf("attempts = %d, messages = %d; want a single attempt and message", attempts, stub.messageCount())
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr string
+	}{
+		{"clean", map[string]string{"Subject": "hi", "To": "a@example.com"}, ""},
+		{"crlf bcc injection", map[string]string{"Subject": "hi\r\nBcc: evil@x.com"}, "Subject"},
+		{"bare lf", map[string]string{"Subject": "hi\nBcc: evil@x.com"}, "Subject"},
+		{"bare cr", map[string]string{"From": "Co\r <a@example.com>"}, "From"},
+		{"body injection", map[string]string{"To": "a@example.com\r\n\r\n<p>spam</p>"}, "To"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHeaders(tt.headers)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one naming %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialSMTPTimesOutOnSilentServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Accept connections but never send the SMTP greeting
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	defer func() {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		default:
+		}
+	}()
+
+	start := time.Now()
+	_, _, err = dialSMTP(models.SMTPDetails{
+		Server:  "127.0.0.1",
+		Port:    ln.Addr().(*net.TCPAddr).Port,
+		Timeout: 100 * time.Millisecond,
+	})
+	elapsed := time.Since(start)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got error %v, want a timeout net.Error", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("dialSMTP took %v, want it to give up after the 100ms timeout", elapsed)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	junkCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(junkCA, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options models.TLSOptions
+		wantErr bool
+		check   func(*tls.Config) bool
+	}{
+		{"defaults", models.TLSOptions{}, false, func(c *tls.Config) bool { return !c.InsecureSkipVerify && c.RootCAs == nil }},
+		{"skip verify", models.TLSOptions{SkipVerify: true}, false, func(c *tls.Config) bool { return c.InsecureSkipVerify }},
+		{"min version", models.TLSOptions{MinVersion: "1.2"}, false, func(c *tls.Config) bool { return c.MinVersion == tls.VersionTLS12 }},
+		{"unknown min version", models.TLSOptions{MinVersion: "1.4"}, true, nil},
+		{"ca file without certificates", models.TLSOptions{CACertFile: junkCA}, true, nil},
+		{"missing ca file", models.TLSOptions{CACertFile: filepath.Join(t.TempDir(), "missing.pem")}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tlsConfig(models.SMTPDetails{Server: "smtp.example.com", TLS: tt.options})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.ServerName != "smtp.example.com" {
+				t.Errorf("ServerName = %q", config.ServerName)
+			}
+			if !tt.check(config) {
+				t.Errorf("unexpected config %+v", config)
+			}
+		})
+	}
+}
+
+func TestMailHeadersDateAndMessageID(t *testing.T) {
+	headers := mailHeaders(models.Sender{Name: "Co", Email: "co@example.com"}, models.Recipient{Email: "someone@example.com"}, "hi")
+
+	date, err := mail.ParseDate(headers["Date"])
+	if err != nil {
+		t.Fatalf("Date %q is not an RFC 5322 date: %v", headers["Date"], err)
+	}
+	if time.Since(date) > time.Minute || time.Until(date) > time.Minute {
+		t.Errorf("Date %v is not the current time", date)
+	}
+
+	if id := headers["Message-ID"]; !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q", id)
+	}
+}
+
+func TestWriteHeadersIsDeterministic(t *testing.T) {
+	headers := mailHeaders(models.Sender{Name: "Co", Email: "co@example.com"}, models.Recipient{Email: "someone@example.com"}, "hi")
+	headers["Auto-Submitted"] = "auto-replied"
+	headers["X-Mailer"] = "leapmailr"
+
+	var first bytes.Buffer
+	writeHeaders(&first, headers)
+	for i := 0; i < 20; i++ {
+		var again bytes.Buffer
+		writeHeaders(&again, headers)
+		if !bytes.Equal(again.Bytes(), first.Bytes()) {
+			t.Fatalf("serialization %d differs:\n%s\nvs\n%s", i, again.Bytes(), first.Bytes())
+		}
+	}
+
+	var names []string
+	for _, line := range strings.Split(strings.TrimSuffix(first.String(), "\r\n"), "\r\n") {
+		names = append(names, strings.SplitN(line, ":", 2)[0])
+	}
+	want := append(append([]string{}, headerOrder...), "Auto-Submitted", "X-Mailer")
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("header order = %v, want %v", names, want)
+	}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 that is its own CA, and
+// the path of a PEM file holding it
+func selfSignedCert(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub SMTP"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestSendMailUsesSTARTTLS(t *testing.T) {
+	cert, caFile := selfSignedCert(t)
+
+	tests := []struct {
+		name    string
+		options models.TLSOptions
+		wantErr bool
+	}{
+		{"trusted via ca file", models.TLSOptions{CACertFile: caFile}, false},
+		{"untrusted certificate", models.TLSOptions{}, true},
+		{"skip verify", models.TLSOptions{SkipVerify: true}, false},
+		{"server below min version", models.TLSOptions{CACertFile: caFile, MinVersion: "1.3"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubSMTP(t)
+			stub.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12}
+
+			// Default auth type: STARTTLS must protect the message, not just the password
+			smtpServer := stub.details()
+			smtpServer.TLS = tt.options
+
+			attempts, err := testSend(smtpServer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != 1 {
+				t.Errorf("attempts = %d, want 1", attempts)
+			}
+			if tt.wantErr {
+				if stub.messageCount() != 0 {
+					t.Error("message was delivered without a trusted TLS connection")
+				}
+				return
+			}
+			if stub.tlsConnections() != 1 || stub.messageCount() != 1 {
+				t.Errorf("tls connections = %d, messages = %d; want 1 and 1", stub.tlsConnections(), stub.messageCount())
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dhawalhost/leapmailr/models"
+)
+
+// xoauth2Auth implements the XOAUTH2 SASL mechanism used by Gmail and
+// Office 365 in place of password authentication
+type xoauth2Auth struct {
+	username string
+	token    string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Same rule as smtp.PlainAuth: never send a bearer token in the clear
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "XOAUTH2", xoauth2Response(a.username, a.token), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// On failure the server sends a JSON error challenge and expects an
+		// empty reply before returning the final error code
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// xoauth2Response builds the initial client response; net/smtp base64-encodes it
+func xoauth2Response(username, token string) []byte {
+	return []byte("user=" + username + "\x01auth=Bearer " + token + "\x01\x01")
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+type oauthToken struct {
+	value  string
+	expiry time.Time
+}
+
+var (
+	oauthMu     sync.Mutex
+	oauthTokens = make(map[string]oauthToken)
+)
+
+// oauthAccessToken returns the configured access token, or mints one from the
+// refresh token and caches it until shortly before it expires
+func oauthAccessToken(oauth models.OAuthDetails, timeout time.Duration) (string, error) {
+	if oauth.RefreshToken == "" {
+		if oauth.AccessToken == "" {
+			return "", errors.New("xoauth2 requires an access token or a refresh token")
+		}
+		return oauth.AccessToken, nil
+	}
+	if oauth.TokenURL == "" {
+		return "", errors.New("xoauth2 refresh token configured without a token URL")
+	}
+
+	oauthMu.Lock()
+	defer oauthMu.Unlock()
+
+	key := oauth.ClientID + "\x00" + oauth.RefreshToken
+	if cached, ok := oauthTokens[key]; ok && time.Now().Before(cached.expiry) {
+		return cached.value, nil
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.PostForm(oauth.TokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {oauth.RefreshToken},
+		"client_id":     {oauth.ClientID},
+		"client_secret": {oauth.ClientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("refreshing access token: token endpoint returned %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("refreshing access token: response has no access_token")
+	}
+
+	// Refresh a minute early so a token never expires mid-session
+	lifetime := time.Duration(body.ExpiresIn)*time.Second - time.Minute
+	oauthTokens[key] = oauthToken{value: body.AccessToken, expiry: time.Now().Add(lifetime)}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/smtp"
+	"testing"
+)
+
+func TestXOAUTH2Response(t *testing.T) {
+	got := string(xoauth2Response("u", "t"))
+	if want := "user=u\x01auth=Bearer t\x01\x01"; got != want {
+		t.Fatalf("xoauth2Response = %q, want %q", got, want)
+	}
+}
+
+func TestXOAUTH2StartRequiresTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  smtp.ServerInfo
+		wantErr bool
+	}{
+		{"remote without tls", smtp.ServerInfo{Name: "smtp.example.com"}, true},
+		{"remote with tls", smtp.ServerInfo{Name: "smtp.example.com", TLS: true}, false},
+		{"localhost without tls", smtp.ServerInfo{Name: "localhost"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &xoauth2Auth{username: "u", token: "t"}
+			mech, resp, err := auth.Start(&tt.server)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected Start to refuse an unencrypted connection")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mech != "XOAUTH2" || string(resp) != string(xoauth2Response("u", "t")) {
+				t.Fatalf("Start = %q, %q", mech, resp)
+			}
+		})
+	}
+}
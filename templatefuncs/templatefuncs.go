@@ -0,0 +1,164 @@
+// Package templatefuncs provides the helper functions available to email
+// templates. Every helper is a pure function of its arguments: none of them
+// touch the filesystem, the network or the current time.
+//
+// Available functions:
+//
+//	upper    {{ .Name | upper }}                    "ADA LOVELACE"
+//	lower    {{ .Email | lower }}                   "ada@example.com"
+//	title    {{ .Name | title }}                    "Ada Lovelace"
+//	default  {{ .Subject | default "No subject" }}  fallback for empty values
+//	date     {{ .Created | date "Jan 2, 2006" }}    time.Time, RFC 3339 string or unix seconds
+//	currency {{ .Amount | currency "USD" }}         "$1,234.50"
+//	raw      {{ .Footer | raw }}                    trusted HTML, left unescaped by html/template
+package templatefuncs
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// currencySymbols maps ISO 4217 codes to the symbol placed before the amount.
+// Codes not listed here are rendered as a "CODE " prefix.
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"INR": "₹",
+	"JPY": "¥",
+}
+
+// FuncMap returns the helpers to register on a template with Funcs
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"title":    Title,
+		"default":  Default,
+		"date":     Date,
+		"currency": Currency,
+		"raw":      Raw,
+	}
+}
+
+// Title upper-cases the first letter of every word and lower-cases the rest
+func Title(s string) string {
+	runes := []rune(s)
+	startOfWord := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) || r == '-' {
+			startOfWord = true
+			continue
+		}
+		if startOfWord {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+		startOfWord = false
+	}
+	return string(runes)
+}
+
+// Raw marks s as trusted HTML so html/template inserts it without escaping.
+// Only use it for markup the server controls, never for submitted values.
+func Raw(s string) htmltemplate.HTML {
+	return htmltemplate.HTML(s)
+}
+
+// Default returns def when given is nil or the zero value of its type
+func Default(def, given interface{}) interface{} {
+	if given == nil || reflect.ValueOf(given).IsZero() {
+		return def
+	}
+	return given
+}
+
+// Date formats value with the given Go time layout. It accepts time.Time,
+// *time.Time, RFC 3339 strings and integer unix seconds (formatted in UTC).
+func Date(layout string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", fmt.Errorf("date: %w", err)
+		}
+		return t.Format(layout), nil
+	case int, int32, int64:
+		return time.Unix(reflect.ValueOf(v).Int(), 0).UTC().Format(layout), nil
+	case uint, uint32, uint64:
+		secs := reflect.ValueOf(v).Uint()
+		if secs > math.MaxInt64 {
+			return "", fmt.Errorf("date: unix time %d out of range", secs)
+		}
+		return time.Unix(int64(secs), 0).UTC().Format(layout), nil
+	default:
+		return "", fmt.Errorf("date: unsupported value of type %T", value)
+	}
+}
+
+// Currency formats amount with two decimals and thousands separators,
+// prefixed with the symbol for the ISO 4217 code
+func Currency(code string, amount interface{}) (string, error) {
+	value, err := toFloat(amount)
+	if err != nil {
+		return "", fmt.Errorf("currency: %w", err)
+	}
+
+	code = strings.ToUpper(code)
+	prefix, ok := currencySymbols[code]
+	if !ok {
+		prefix = code + " "
+	}
+
+	// Round before deciding the sign so tiny negatives don't print as "-$0.00"
+	rounded := math.Round(math.Abs(value)*100) / 100
+	sign := ""
+	if value < 0 && rounded > 0 {
+		sign = "-"
+	}
+	formatted := strconv.FormatFloat(rounded, 'f', 2, 64)
+	whole, fraction := formatted[:len(formatted)-3], formatted[len(formatted)-3:]
+	return sign + prefix + groupThousands(whole) + fraction, nil
+}
+
+func groupThousands(digits string) string {
+	var sb strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(d)
+	}
+	return sb.String()
+}
+
+func toFloat(value interface{}) (float64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return strconv.ParseFloat(v.String(), 64)
+	default:
+		return 0, fmt.Errorf("unsupported value of type %T", value)
+	}
+}
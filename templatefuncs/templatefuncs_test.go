@@ -0,0 +1,71 @@
+package templatefuncs
+
+import (
+	htmltemplate "html/template"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateRendering(t *testing.T) {
+	tests := []struct {
+		name string
+		tmpl string
+		data map[string]interface{}
+		want string
+	}{
+		{"currency", `{{ .amount | currency "USD" }}`, map[string]interface{}{"amount": 1234.5}, "$1,234.50"},
+		{"currency unknown code", `{{ .amount | currency "chf" }}`, map[string]interface{}{"amount": 3}, "CHF 3.00"},
+		{"date from time", `{{ .created | date "Jan 2, 2006" }}`, map[string]interface{}{"created": time.Date(2024, time.March, 5, 10, 0, 0, 0, time.UTC)}, "Mar 5, 2024"},
+		{"date from string", `{{ .created | date "Jan 2, 2006" }}`, map[string]interface{}{"created": "2024-03-05T10:00:00Z"}, "Mar 5, 2024"},
+		{"date from unix", `{{ .created | date "Jan 2, 2006" }}`, map[string]interface{}{"created": int64(1709632800)}, "Mar 5, 2024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := htmltemplate.New("t").Funcs(htmltemplate.FuncMap(FuncMap())).Parse(tt.tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sb strings.Builder
+			if err := tmpl.Execute(&sb, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if sb.String() != tt.want {
+				t.Fatalf("got %q, want %q", sb.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	tests := []struct {
+		amount interface{}
+		want   string
+	}{
+		{0, "$0.00"},
+		{-0.001, "$0.00"},
+		{-0.005, "-$0.01"},
+		{-1234567.891, "-$1,234,567.89"},
+		{"99.999", "$100.00"},
+	}
+	for _, tt := range tests {
+		got, err := Currency("USD", tt.amount)
+		if err != nil {
+			t.Fatalf("Currency(%v): %v", tt.amount, err)
+		}
+		if got != tt.want {
+			t.Errorf("Currency(%v) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestDateRejectsOutOfRangeUnixTime(t *testing.T) {
+	if _, err := Date(time.RFC3339, uint64(math.MaxInt64)+1); err == nil {
+		t.Fatal("expected an error for a uint64 above MaxInt64")
+	}
+	got, err := Date(time.RFC3339, uint64(0))
+	if err != nil || got != "1970-01-01T00:00:00Z" {
+		t.Fatalf("Date(0) = %q, %v", got, err)
+	}
+}